@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"github.com/pkg/errors"
+)
+
+// binaryMagic 是MemoryBloomFilter二进制格式的魔数，用来快速识别文件类型并拒绝脏数据
+const binaryMagic = "BFv1"
+
+// binaryVersion 跟在魔数后面的版本号，未来格式变化时可以据此向前兼容
+const binaryVersion byte = 1
+
+// binaryHeaderSize: 4字节magic + 1字节version + 4字节k + 8字节n_bits
+const binaryHeaderSize = len(binaryMagic) + 1 + 4 + 8
+
+/*
+MarshalBinary 按照little-endian布局把filter序列化成可跨语言、跨Go版本移植的二进制格式：
+
+	magic     [4]byte  "BFv1"
+	version   byte
+	k         uint32
+	n_bits    uint64
+	bits      []byte   len(bs)个int64，原样按little-endian写出
+
+这个格式不依赖gob，因此不会因为BitSets的内部表示变化而失去兼容性。
+*/
+func (filter *MemoryBloomFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, binaryHeaderSize+len(filter.bs)*8)
+	copy(data, binaryMagic)
+	data[len(binaryMagic)] = binaryVersion
+	binary.LittleEndian.PutUint32(data[len(binaryMagic)+1:], uint32(filter.k))
+	binary.LittleEndian.PutUint64(data[len(binaryMagic)+5:], uint64(filter.n))
+
+	offset := binaryHeaderSize
+	for _, word := range filter.bs {
+		binary.LittleEndian.PutUint64(data[offset:], uint64(word))
+		offset += 8
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary 解析MarshalBinary写出的格式，校验magic和version后原样恢复bs
+func (filter *MemoryBloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize {
+		return errors.New("bloom_filter: truncated binary data")
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.Errorf("bloom_filter: bad magic %q", data[:len(binaryMagic)])
+	}
+
+	version := data[len(binaryMagic)]
+	if version != binaryVersion {
+		return errors.Errorf("bloom_filter: unsupported version %d", version)
+	}
+
+	k := binary.LittleEndian.Uint32(data[len(binaryMagic)+1:])
+	nBits := binary.LittleEndian.Uint64(data[len(binaryMagic)+5:])
+	wordBytes := data[binaryHeaderSize:]
+	if len(wordBytes)%8 != 0 {
+		return errors.New("bloom_filter: truncated bitset payload")
+	}
+
+	bs := make(BitSets, len(wordBytes)/8)
+	for i := range bs {
+		bs[i] = int64(binary.LittleEndian.Uint64(wordBytes[i*8:]))
+	}
+
+	filter.k = uint(k)
+	filter.n = uint(nBits)
+	filter.bs = bs
+
+	return nil
+}
+
+// Union 把other的所有置位OR进filter，要求两者的k和n完全一致
+func (filter *MemoryBloomFilter) Union(other *MemoryBloomFilter) error {
+	if filter.k != other.k || filter.n != other.n {
+		return errors.New("bloom_filter: Union requires matching k and n")
+	}
+
+	for i := range filter.bs {
+		filter.bs[i] |= other.bs[i]
+	}
+
+	return nil
+}
+
+// Intersect 把filter和other的置位AND在一起，要求两者的k和n完全一致
+func (filter *MemoryBloomFilter) Intersect(other *MemoryBloomFilter) error {
+	if filter.k != other.k || filter.n != other.n {
+		return errors.New("bloom_filter: Intersect requires matching k and n")
+	}
+
+	for i := range filter.bs {
+		filter.bs[i] &= other.bs[i]
+	}
+
+	return nil
+}
+
+// popcount 统计bs中置为1的比特总数
+func (filter *MemoryBloomFilter) popcount() uint64 {
+	var count uint64
+	for _, word := range filter.bs {
+		count += uint64(bits.OnesCount64(uint64(word)))
+	}
+
+	return count
+}
+
+// EstimateCardinality 用Swamidass-Baldi估计器 -(m/k)*ln(1-X/m) 估算已插入的不同元素个数，
+// 其中m是总比特数，X是置位的比特数，可以在不落地实际计数器的情况下判断filter的填充程度
+func (filter *MemoryBloomFilter) EstimateCardinality() uint64 {
+	m := float64(filter.n)
+	x := float64(filter.popcount())
+	k := float64(filter.k)
+
+	return uint64(-(m / k) * math.Log(1-x/m))
+}
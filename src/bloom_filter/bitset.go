@@ -23,6 +23,53 @@ func (bs BitSets) IsSet(index uint) bool {
 	return (word | (1 << bit)) == word
 }
 
+// NibbleCells 是按4bit打包的计数数组，每个byte存两个计数器，单元饱和值为15，
+// 相比一个计数器占一整个byte，可以把计数布隆过滤器的内存占用减半。
+type NibbleCells []uint8
+
+// NewNibbleCells 创建一个容量为n个计数器的 NibbleCells
+func NewNibbleCells(n uint) NibbleCells {
+	return make(NibbleCells, n/2+1)
+}
+
+// Get 返回index位置的计数值
+func (nc NibbleCells) Get(index uint) uint8 {
+	b := nc[index/2]
+	if index%2 == 0 {
+		return b & 0x0f
+	}
+	return b >> 4
+}
+
+// set 把index位置的计数值设为value，value必须在0~15之间
+func (nc NibbleCells) set(index uint, value uint8) {
+	i, high := index/2, index%2 == 1
+	if high {
+		nc[i] = (nc[i] & 0x0f) | (value << 4)
+	} else {
+		nc[i] = (nc[i] & 0xf0) | value
+	}
+}
+
+// Inc 给index位置的计数器加1，饱和于15，一旦饱和就不再增减，避免回绕导致的误判
+func (nc NibbleCells) Inc(index uint) {
+	if v := nc.Get(index); v < 15 {
+		nc.set(index, v+1)
+	}
+}
+
+// Dec 给index位置的计数器减1，跳过已经饱和(15)的计数器
+func (nc NibbleCells) Dec(index uint) {
+	if v := nc.Get(index); v > 0 && v < 15 {
+		nc.set(index, v-1)
+	}
+}
+
+// Len 返回计数器的个数
+func (nc NibbleCells) Len() uint {
+	return uint(len(nc)) * 2
+}
+
 /*import (
 "bytes"
 )
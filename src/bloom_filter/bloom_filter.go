@@ -4,9 +4,9 @@ import (
 	"os"
 	"log"
 	"fmt"
+	"io/ioutil"
 
 	"compress/gzip"
-	"encoding/gob"
 
 	"github.com/pkg/errors"
 	"github.com/gomodule/redigo/redis"
@@ -44,6 +44,7 @@ type FileBloomFilter struct {
 
 type MemoryBloomFilter struct {
 	k  uint
+	n  uint // 比特位总数，注意bs是按int64打包的，len(bs)是字数而不是位数
 	bs BitSets
 }
 
@@ -64,12 +65,27 @@ func HashData(data []byte, seed uint) uint {
 	return uint(m.Sum64())
 }
 
+// hashPair 计算两个基础哈希值h1、h2，作为Kirsch-Mitzenmacher双重哈希的输入。
+// murmur3已经是充分混合的哈希，这里不再像HashData那样多算一次sha256。
+func hashPair(data []byte) (uint64, uint64) {
+	h1, h2 := murmur3.Sum128(data)
+	return h1, h2
+}
+
+// kirschMitzenmacher 用两个基础哈希h1、h2模拟出第i个独立哈希函数的结果：
+// g_i(x) = h1 + i*h2 + i*i，已经证明其误判率的渐近表现与k个独立哈希函数一致，
+// 但只需要一次murmur3计算，避免了每个seed都重新跑一遍sha256+murmur3。
+func kirschMitzenmacher(h1, h2 uint64, i uint) uint {
+	return uint(h1 + uint64(i)*h2 + uint64(i*i))
+}
+
 /*
 create a memory bloom filter
  */
 func NewMemoryBloomFilter(n uint, k uint) *MemoryBloomFilter {
 	return &MemoryBloomFilter{
 		k:  k,
+		n:  n,
 		bs: NewBitSets(n),
 	}
 }
@@ -78,9 +94,10 @@ func NewMemoryBloomFilter(n uint, k uint) *MemoryBloomFilter {
 compute hash value with capacity of k
  */
 func (filter *MemoryBloomFilter) Put(data []byte) {
-	l := uint(len(filter.bs))
+	l := filter.n
+	h1, h2 := hashPair(data)
 	for i := uint(0); i < filter.k; i++ {
-		filter.bs.Set(HashData(data, i) % l)
+		filter.bs.Set(kirschMitzenmacher(h1, h2, i) % l)
 	}
 }
 
@@ -91,10 +108,11 @@ func (filter *MemoryBloomFilter) PutString(data string) {
 
 // Has 推测记录是否已存在
 func (filter *MemoryBloomFilter) Has(data []byte) bool {
-	l := uint(len(filter.bs))
+	l := filter.n
+	h1, h2 := hashPair(data)
 
 	for i := uint(0); i < filter.k; i++ {
-		if !filter.bs.IsSet(HashData(data, i) % l) {
+		if !filter.bs.IsSet(kirschMitzenmacher(h1, h2, i) % l) {
 			return false
 		}
 	}
@@ -128,6 +146,7 @@ func (filter *FileBloomFilter) Close() {
 	filter.bs = nil
 }
 
+// store 把filter序列化成便携的BFv1二进制格式后再gzip落盘，取代之前的gob编码
 func (filter *FileBloomFilter) store() {
 	f, err := os.Create(filter.target)
 	if err != nil {
@@ -138,9 +157,11 @@ func (filter *FileBloomFilter) store() {
 	gzipWriter := gzip.NewWriter(f)
 	defer gzipWriter.Close()
 
-	encoder := gob.NewEncoder(gzipWriter)
-	err = encoder.Encode(filter.bs)
+	data, err := filter.MarshalBinary()
 	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "MarshalBinary"))
+	}
+	if _, err := gzipWriter.Write(data); err != nil {
 		log.Fatalf("%+v", errors.Wrap(err, "gzip"))
 	}
 }
@@ -160,63 +181,135 @@ func (filter *FileBloomFilter) reStore() {
 		log.Fatalf("%+v", errors.Wrap(err, "Ungzip"))
 	}
 
-	decoder := gob.NewDecoder(gzipReader)
-	err = decoder.Decode(&filter.bs)
+	data, err := ioutil.ReadAll(gzipReader)
 	if err != nil {
-		log.Fatalf("%+v", errors.Wrap(err, "gob decode"))
+		log.Fatalf("%+v", errors.Wrap(err, "gunzip"))
+	}
+
+	if err := filter.UnmarshalBinary(data); err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "UnmarshalBinary"))
 	}
 }
 
+// hasBitsScript 在Redis端按顺序GETBIT，一旦遇到0就立即返回，省掉一次次来回的网络等待
+const hasBitsScript = `
+for i = 1, #ARGV do
+	if redis.call('GETBIT', KEYS[1], ARGV[i]) == 0 then
+		return 0
+	end
+end
+return 1
+`
+
+// NewRedisBloomFilter 创建一个基于Redis字符串+SETBIT/GETBIT的布隆过滤器。
+// redisKey由(n, k)决定，同一个(n, k)的多个filter实例会共享并不断累加同一个Redis key，
+// 这是有意的设计：方便多进程共用同一个过滤器。如果需要从空白状态开始，显式调用Clear。
 func NewRedisBloomFilter(cli redis.Conn, n, k uint) *RedisBloomFilter {
-	filter := &RedisBloomFilter{
+	return &RedisBloomFilter{
 		cli: cli,
 		n:   n,
 		k:   k,
 	}
-	length, _ := redis.Int64(cli.Do("LLEN", filter.redisKey()))
-	if uint(length) != n {
-		bs := make([]interface{}, n)
-		pushArgs := []interface{}{filter.redisKey()}
-		pushArgs = append(pushArgs, bs...)
-		cli.Do("DEL", filter.redisKey())
-		cli.Do("LPUSH", pushArgs...)
-	}
+}
 
-	return filter
+// Clear 删除底层的Redis key，把filter重置为空
+func (filter *RedisBloomFilter) Clear() {
+	if _, err := filter.cli.Do("DEL", filter.redisKey()); err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "DEL"))
+	}
 }
 
-func (filter *RedisBloomFilter) Put(data []byte) {
+// indices 返回data对应的k个比特位下标
+func (filter *RedisBloomFilter) indices(data []byte) []uint {
+	h1, h2 := hashPair(data)
+	indices := make([]uint, filter.k)
 	for i := uint(0); i < filter.k; i++ {
-		_, err := filter.cli.Do("LSET", filter.redisKey(), HashData(data, i)%filter.n, "1")
-		if err != nil {
-			log.Fatalf("%+v", errors.Wrap(err, "LSET"))
-		}
+		indices[i] = kirschMitzenmacher(h1, h2, i) % filter.n
 	}
+
+	return indices
+}
+
+// evalArgs 把hasBitsScript和下标列表拼成EVAL命令的参数：script、numkeys、key、index...
+func (filter *RedisBloomFilter) evalArgs(indices []uint) []interface{} {
+	args := make([]interface{}, 0, len(indices)+3)
+	args = append(args, hasBitsScript, 1, filter.redisKey())
+	for _, index := range indices {
+		args = append(args, index)
+	}
+
+	return args
+}
+
+// Put 把data对应的k个比特位通过pipeline一次性SETBIT，整个Put只需要一次网络往返
+func (filter *RedisBloomFilter) Put(data []byte) {
+	filter.PutMany([][]byte{data})
 }
 
 func (filter *RedisBloomFilter) PutString(data string) {
 	filter.Put([]byte(data))
 }
 
-func (filter *RedisBloomFilter) Has(data []byte) bool {
-	for i := uint(0); i < filter.k; i++ {
-		index := HashData(data, i) % filter.n
-		value, err := redis.String(filter.cli.Do("LINDEX", filter.redisKey(), index))
-		if err != nil {
-			log.Fatalf("%+v", errors.Wrap(err, "LINDEX"))
+// PutMany 把一批data对应的bit位通过pipeline批量SETBIT，batch维和k维都被合并进一次网络往返
+func (filter *RedisBloomFilter) PutMany(dataList [][]byte) {
+	var sent int
+	for _, data := range dataList {
+		for _, index := range filter.indices(data) {
+			if err := filter.cli.Send("SETBIT", filter.redisKey(), index, 1); err != nil {
+				log.Fatalf("%+v", errors.Wrap(err, "SETBIT"))
+			}
+			sent++
 		}
-		if value != "1" {
-			return false
+	}
+
+	if err := filter.cli.Flush(); err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "Flush"))
+	}
+	for i := 0; i < sent; i++ {
+		if _, err := filter.cli.Receive(); err != nil {
+			log.Fatalf("%+v", errors.Wrap(err, "Receive"))
 		}
 	}
+}
 
-	return true
+// Has 用Lua脚本在Redis端把k次GETBIT合并成一次往返，并在遇到第一个0位时短路返回
+func (filter *RedisBloomFilter) Has(data []byte) bool {
+	hit, err := redis.Int(filter.cli.Do("EVAL", filter.evalArgs(filter.indices(data))...))
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "EVAL"))
+	}
+
+	return hit == 1
 }
 
 func (filter *RedisBloomFilter) HasString(data string) bool {
 	return filter.Has([]byte(data))
 }
 
+// HasMany 把一批data的Has查询通过pipeline批量EVAL，batch维和k维都被合并进一次网络往返
+func (filter *RedisBloomFilter) HasMany(dataList [][]byte) []bool {
+	for _, data := range dataList {
+		if err := filter.cli.Send("EVAL", filter.evalArgs(filter.indices(data))...); err != nil {
+			log.Fatalf("%+v", errors.Wrap(err, "EVAL"))
+		}
+	}
+
+	if err := filter.cli.Flush(); err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "Flush"))
+	}
+
+	result := make([]bool, len(dataList))
+	for i := range dataList {
+		hit, err := redis.Int(filter.cli.Receive())
+		if err != nil {
+			log.Fatalf("%+v", errors.Wrap(err, "Receive"))
+		}
+		result[i] = hit == 1
+	}
+
+	return result
+}
+
 // Close 只将cli设置为nil, 关闭redis连接的操作放在调用处
 func (filter *RedisBloomFilter) Close() {
 	filter.cli = nil
@@ -52,6 +52,271 @@ func TestRedisBloomFilter(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	var filter BloomFilter = NewRedisBloomFilter(cli, 2000, 5)
-	RandTest(t, filter, 50)
+	var filter BloomFilter = NewRedisBloomFilter(cli, 64<<20, 5)
+	RandTest(t, filter, 50000)
+}
+
+func TestRedisBloomFilterMany(t *testing.T) {
+	cli, err := redis.DialURL("redis://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewRedisBloomFilter(cli, 64<<20, 5)
+
+	n := 50000
+	dataList := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		dataList[i] = []byte(fmt.Sprintf("m%d", i))
+	}
+	filter.PutMany(dataList)
+
+	hits := filter.HasMany(dataList)
+	var missNumbers int
+	for _, hit := range hits {
+		if !hit {
+			missNumbers++
+		}
+	}
+
+	hitRate := float64(n-missNumbers) / float64(n)
+	fmt.Printf("hit rate: %f\n", hitRate)
+
+	if hitRate < 0.9 {
+		t.Fatalf("Oh, fuck. hit rate is %f, too low", hitRate)
+	}
+}
+
+// CountingRemoveTest 插入n条记录，删掉其中一半，验证保留下来的一半没有假阴性
+func CountingRemoveTest(t *testing.T, filter *CountingBloomFilter, n int) {
+	for i := 0; i < n; i++ {
+		filter.PutString(fmt.Sprintf("c%d", i))
+	}
+
+	for i := 0; i < n; i += 2 {
+		filter.RemoveString(fmt.Sprintf("c%d", i))
+	}
+
+	var missNumbers int
+	for i := 1; i < n; i += 2 {
+		if !filter.HasString(fmt.Sprintf("c%d", i)) {
+			missNumbers++
+		}
+	}
+
+	hitRate := float64(n/2-missNumbers) / float64(n/2)
+	fmt.Printf("retained hit rate: %f\n", hitRate)
+
+	if missNumbers != 0 {
+		t.Fatalf("%d retained records reported missing after Remove, hit rate is %f", missNumbers, hitRate)
+	}
+}
+
+func TestCountingBloomFilterRemove(t *testing.T) {
+	CountingRemoveTest(t, NewCountingBloomFilter(64<<20, 5), 50000)
+}
+
+func TestPackedCountingBloomFilter(t *testing.T) {
+	var filter BloomFilter = NewPackedCountingBloomFilter(64<<20, 5)
+	RandTest(t, filter, 50000)
+}
+
+func TestPackedCountingBloomFilterRemove(t *testing.T) {
+	CountingRemoveTest(t, NewPackedCountingBloomFilter(64<<20, 5), 50000)
+}
+
+func TestFileCountingBloomFilter(t *testing.T) {
+	target := "counting_bloom.tmp"
+	defer os.Remove(target)
+
+	n := 50000
+	filter := NewFileCountingBloomFilter(target, 64<<20, 5)
+	for i := 0; i < n; i++ {
+		filter.PutString(fmt.Sprintf("fc%d", i))
+	}
+	filter.Close()
+
+	restored := NewFileCountingBloomFilter(target, 64<<20, 5)
+	defer restored.Close()
+
+	var missNumbers int
+	for i := 0; i < n; i++ {
+		if !restored.HasString(fmt.Sprintf("fc%d", i)) {
+			missNumbers++
+		}
+	}
+
+	hitRate := float64(n-missNumbers) / float64(n)
+	fmt.Printf("hit rate: %f\n", hitRate)
+
+	if hitRate < 0.9 {
+		t.Fatalf("Oh, fuck. hit rate is %f, too low", hitRate)
+	}
+}
+
+func TestScalableBloomFilter(t *testing.T) {
+	filter := NewScalableBloomFilter(1000, 0.01, 2, 0.9)
+	n := 50000
+
+	for i := 0; i < n; i++ {
+		filter.PutString(fmt.Sprintf("g%d", i))
+	}
+
+	if filter.Len() < 2 {
+		t.Fatalf("expected the filter to have grown past its first slice, got %d slices", filter.Len())
+	}
+
+	var missNumbers int
+	for i := 0; i < n; i++ {
+		existsRecord := fmt.Sprintf("g%d", i)
+		notExistsRecord := fmt.Sprintf("gg%d", i)
+		if !filter.HasString(existsRecord) {
+			missNumbers++
+		}
+		if filter.HasString(notExistsRecord) {
+			missNumbers++
+		}
+	}
+
+	hitRate := float64(2*n-missNumbers) / float64(2*n)
+	fmt.Printf("hit rate: %f, slices: %d\n", hitRate, filter.Len())
+
+	if hitRate < 0.9 {
+		t.Fatalf("Oh, fuck. hit rate is %f, too low", hitRate)
+	}
+}
+
+func TestFileScalableBloomFilter(t *testing.T) {
+	target := "scalable_bloom.tmp"
+	defer os.Remove(target)
+
+	n := 5000
+	filter := NewFileScalableBloomFilter(target, 1000, 0.01, 2, 0.9)
+	for i := 0; i < n; i++ {
+		filter.PutString(fmt.Sprintf("f%d", i))
+	}
+	slices := filter.Len()
+	filter.Close()
+
+	restored := NewFileScalableBloomFilter(target, 1000, 0.01, 2, 0.9)
+	defer restored.Close()
+
+	if restored.Len() != slices {
+		t.Fatalf("expected %d slices after reload, got %d", slices, restored.Len())
+	}
+
+	var missNumbers int
+	for i := 0; i < n; i++ {
+		if !restored.HasString(fmt.Sprintf("f%d", i)) {
+			missNumbers++
+		}
+	}
+
+	hitRate := float64(n-missNumbers) / float64(n)
+	fmt.Printf("hit rate: %f\n", hitRate)
+
+	if hitRate < 0.9 {
+		t.Fatalf("Oh, fuck. hit rate is %f, too low", hitRate)
+	}
+}
+
+func BenchmarkMemoryBloomFilterPut(b *testing.B) {
+	filter := NewMemoryBloomFilter(64<<20, 5)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		filter.PutString(fmt.Sprintf("b%d", i))
+	}
+}
+
+func TestMemoryBloomFilterMarshalBinary(t *testing.T) {
+	filter := NewMemoryBloomFilter(64<<10, 5)
+	for i := 0; i < 1000; i++ {
+		filter.PutString(fmt.Sprintf("s%d", i))
+	}
+
+	data, err := filter.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &MemoryBloomFilter{}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !restored.HasString(fmt.Sprintf("s%d", i)) {
+			t.Fatalf("restored filter lost record s%d", i)
+		}
+	}
+}
+
+func TestMemoryBloomFilterUnionIntersect(t *testing.T) {
+	a := NewMemoryBloomFilter(64<<10, 5)
+	b := NewMemoryBloomFilter(64<<10, 5)
+
+	a.PutString("only-a")
+	b.PutString("only-b")
+	a.PutString("both")
+	b.PutString("both")
+
+	union := NewMemoryBloomFilter(64<<10, 5)
+	if err := union.Union(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := union.Union(b); err != nil {
+		t.Fatal(err)
+	}
+	if !union.HasString("only-a") || !union.HasString("only-b") {
+		t.Fatal("union should contain records from both filters")
+	}
+
+	if err := a.Intersect(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.HasString("both") {
+		t.Fatal("intersect should retain records present in both filters")
+	}
+}
+
+func TestMemoryBloomFilterEstimateCardinality(t *testing.T) {
+	n := 10000
+	filter := NewMemoryBloomFilter(64<<20, 5)
+	for i := 0; i < n; i++ {
+		filter.PutString(fmt.Sprintf("e%d", i))
+	}
+
+	estimate := filter.EstimateCardinality()
+	ratio := float64(estimate) / float64(n)
+	if ratio < 0.9 || ratio > 1.1 {
+		t.Fatalf("cardinality estimate %d too far from actual %d", estimate, n)
+	}
+}
+
+// TestMemoryBloomFilterEstimateCardinalityHighFill 用一个刚好填到约63%比特位的filter
+// (k*n/m=1, 对应1-e^-1) 来考察估计器的非线性区间，而不是只在接近空的低填充率下通过
+func TestMemoryBloomFilterEstimateCardinalityHighFill(t *testing.T) {
+	n := 40000
+	filter := NewMemoryBloomFilter(200000, 5)
+	for i := 0; i < n; i++ {
+		filter.PutString(fmt.Sprintf("h%d", i))
+	}
+
+	estimate := filter.EstimateCardinality()
+	ratio := float64(estimate) / float64(n)
+	if ratio < 0.9 || ratio > 1.1 {
+		t.Fatalf("cardinality estimate %d too far from actual %d", estimate, n)
+	}
+}
+
+func BenchmarkMemoryBloomFilterHas(b *testing.B) {
+	filter := NewMemoryBloomFilter(64<<20, 5)
+	for i := 0; i < 50000; i++ {
+		filter.PutString(fmt.Sprintf("b%d", i))
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		filter.HasString(fmt.Sprintf("b%d", i%50000))
+	}
 }
\ No newline at end of file
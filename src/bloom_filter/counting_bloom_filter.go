@@ -0,0 +1,196 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	gob.Register(ByteCells{})
+	gob.Register(NibbleCells{})
+}
+
+/*
+CountingBloomFilter 在标准布隆过滤器的基础上把每个位替换成一个饱和计数器，
+从而支持 Remove。计数器在达到上限后不再增加（也不再允许减少），
+这是计数布隆过滤器的正确性前提：一旦饱和的计数器被误减，对应的位就可能过早归零，进而引入假阴性。
+*/
+type CountingBloomFilter struct {
+	k     uint
+	cells CountingCells
+}
+
+// CountingCells 抽象计数存储，ByteCells 每个计数器占一个byte(饱和于255)，
+// NibbleCells 每个计数器占4bit(饱和于15)，用来在精度和内存之间取舍
+type CountingCells interface {
+	Get(index uint) uint8
+	Inc(index uint)
+	Dec(index uint)
+	Len() uint
+}
+
+// ByteCells 是每个计数器占一个byte的计数存储，饱和值为255
+type ByteCells []uint8
+
+// NewByteCells 创建一个容量为n个计数器的 ByteCells
+func NewByteCells(n uint) ByteCells {
+	return make(ByteCells, n)
+}
+
+func (bc ByteCells) Get(index uint) uint8 {
+	return bc[index]
+}
+
+func (bc ByteCells) Inc(index uint) {
+	if bc[index] < 255 {
+		bc[index]++
+	}
+}
+
+func (bc ByteCells) Dec(index uint) {
+	if bc[index] > 0 && bc[index] < 255 {
+		bc[index]--
+	}
+}
+
+func (bc ByteCells) Len() uint {
+	return uint(len(bc))
+}
+
+// NewCountingBloomFilter 创建一个计数布隆过滤器，每个计数器占一个byte
+func NewCountingBloomFilter(n uint, k uint) *CountingBloomFilter {
+	return &CountingBloomFilter{
+		k:     k,
+		cells: NewByteCells(n),
+	}
+}
+
+// NewPackedCountingBloomFilter 创建一个计数布隆过滤器，每个计数器只占4bit，内存减半但饱和值降为15
+func NewPackedCountingBloomFilter(n uint, k uint) *CountingBloomFilter {
+	return &CountingBloomFilter{
+		k:     k,
+		cells: NewNibbleCells(n),
+	}
+}
+
+// Put 给k个计数单元分别加1
+func (filter *CountingBloomFilter) Put(data []byte) {
+	l := filter.cells.Len()
+	for i := uint(0); i < filter.k; i++ {
+		filter.cells.Inc(HashData(data, i) % l)
+	}
+}
+
+// PutString 添加一条string记录
+func (filter *CountingBloomFilter) PutString(data string) {
+	filter.Put([]byte(data))
+}
+
+// Has 推测记录是否已存在
+func (filter *CountingBloomFilter) Has(data []byte) bool {
+	l := filter.cells.Len()
+	for i := uint(0); i < filter.k; i++ {
+		if filter.cells.Get(HashData(data, i)%l) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasString 推测记录是否已存在
+func (filter *CountingBloomFilter) HasString(data string) bool {
+	return filter.Has([]byte(data))
+}
+
+// Remove 给k个计数单元分别减1，跳过已经饱和的计数单元
+func (filter *CountingBloomFilter) Remove(data []byte) {
+	l := filter.cells.Len()
+	for i := uint(0); i < filter.k; i++ {
+		filter.cells.Dec(HashData(data, i) % l)
+	}
+}
+
+// RemoveString 删除一条string记录
+func (filter *CountingBloomFilter) RemoveString(data string) {
+	filter.Remove([]byte(data))
+}
+
+// Close 关闭bloom filter
+func (filter *CountingBloomFilter) Close() {
+	filter.cells = nil
+}
+
+// FileCountingBloomFilter 是落盘的计数布隆过滤器，以gzip压缩的gob编码落盘(FileBloomFilter用的是BFv1二进制格式)
+type FileCountingBloomFilter struct {
+	*CountingBloomFilter
+	target string
+}
+
+// NewFileCountingBloomFilter 创建一个落盘的计数布隆过滤器
+func NewFileCountingBloomFilter(target string, n uint, k uint) *FileCountingBloomFilter {
+	filter := &FileCountingBloomFilter{
+		NewCountingBloomFilter(n, k), target,
+	}
+	filter.reStore()
+
+	return filter
+}
+
+func (filter *FileCountingBloomFilter) Close() {
+	filter.store()
+	filter.cells = nil
+}
+
+// countingFilterData 是 CountingBloomFilter 的可序列化形式。gob只有在interface是
+// 某个struct的字段时才会带上具体类型信息，直接Encode(filter.cells)这个裸interface值
+// 写出的是具体类型本身，decoder.Decode(&filter.cells)在读回来时找不到interface的包装
+// 信息会直接报错退出，所以这里和scalableFilterData一样包一层struct。
+type countingFilterData struct {
+	Cells CountingCells
+}
+
+func (filter *FileCountingBloomFilter) store() {
+	f, err := os.Create(filter.target)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "Open file"))
+	}
+	defer f.Close()
+
+	gzipWriter := gzip.NewWriter(f)
+	defer gzipWriter.Close()
+
+	encoder := gob.NewEncoder(gzipWriter)
+	err = encoder.Encode(countingFilterData{Cells: filter.cells})
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "gzip"))
+	}
+}
+
+func (filter *FileCountingBloomFilter) reStore() {
+	f, err := os.Open(filter.target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		log.Fatalf("%+v", errors.Wrap(err, "Open file"))
+	}
+	defer f.Close()
+
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "Ungzip"))
+	}
+
+	var data countingFilterData
+	decoder := gob.NewDecoder(gzipReader)
+	err = decoder.Decode(&data)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "gob decode"))
+	}
+	filter.cells = data.Cells
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"log"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ScalableBloomFilter 实现了 Almeida et al. 提出的可伸缩布隆过滤器：
+不再要求调用方提前确定容量 n，而是由一组容量递增的 MemoryBloomFilter "slice" 组成，
+当最后一个 slice 写满后自动追加下一个，同时收紧误判率：第i个 slice 的误判率为 P*r^i，
+因此整体 FP 率的上限是 P + P*r + P*r^2 + ... = P/(1-r)，而不是 P 本身。
+*/
+type ScalableBloomFilter struct {
+	p  float64 // 目标误判率
+	r  float64 // 误判率收紧系数，典型取值 0.8~0.9
+	s  float64 // 容量增长系数，典型取值 2
+	n0 uint    // 第一个 slice 的容量
+
+	slices []*MemoryBloomFilter
+	caps   []uint // 每个 slice 的容量
+	counts []uint // 每个 slice 已插入的元素个数
+}
+
+// NewScalableBloomFilter 创建一个可伸缩布隆过滤器
+// n0 是第一个 slice 的预期容量，p 是第一个 slice 的误判率(整体上限为 p/(1-r))，s 是容量增长系数，r 是误判率收紧系数
+func NewScalableBloomFilter(n0 uint, p float64, s float64, r float64) *ScalableBloomFilter {
+	filter := &ScalableBloomFilter{
+		p:  p,
+		r:  r,
+		s:  s,
+		n0: n0,
+	}
+	filter.addSlice()
+
+	return filter
+}
+
+// addSlice 按照 Almeida et al. 的公式追加一个新的 slice
+func (filter *ScalableBloomFilter) addSlice() {
+	i := len(filter.slices)
+
+	capacity := uint(float64(filter.n0) * math.Pow(filter.s, float64(i)))
+	errorRate := filter.p * math.Pow(filter.r, float64(i))
+
+	k := uint(math.Ceil(-math.Log2(errorRate)))
+	m := uint(math.Ceil(float64(k) * float64(capacity) / math.Ln2))
+
+	filter.slices = append(filter.slices, NewMemoryBloomFilter(m, k))
+	filter.caps = append(filter.caps, capacity)
+	filter.counts = append(filter.counts, 0)
+}
+
+// currentSlice 返回当前正在写入的 slice 下标
+func (filter *ScalableBloomFilter) currentSlice() int {
+	return len(filter.slices) - 1
+}
+
+// Put 写入当前 slice，一旦其插入计数达到容量就追加一个新的 slice
+func (filter *ScalableBloomFilter) Put(data []byte) {
+	i := filter.currentSlice()
+	filter.slices[i].Put(data)
+	filter.counts[i]++
+
+	if filter.counts[i] >= filter.caps[i] {
+		filter.addSlice()
+	}
+}
+
+// PutString 添加一条string记录
+func (filter *ScalableBloomFilter) PutString(data string) {
+	filter.Put([]byte(data))
+}
+
+// Has 只要任意一个 slice 命中就认为记录存在
+func (filter *ScalableBloomFilter) Has(data []byte) bool {
+	for _, slice := range filter.slices {
+		if slice.Has(data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasString 推测记录是否已存在
+func (filter *ScalableBloomFilter) HasString(data string) bool {
+	return filter.Has([]byte(data))
+}
+
+// Close 关闭bloom filter
+func (filter *ScalableBloomFilter) Close() {
+	filter.slices = nil
+}
+
+// Len 返回当前的 slice 数量
+func (filter *ScalableBloomFilter) Len() int {
+	return len(filter.slices)
+}
+
+// EstimateFillRatio 返回当前 slice 的填充率，用于观测何时即将扩容
+func (filter *ScalableBloomFilter) EstimateFillRatio() float64 {
+	i := filter.currentSlice()
+	return float64(filter.counts[i]) / float64(filter.caps[i])
+}
+
+// scalableSliceData 是单个 slice 的可序列化形式
+type scalableSliceData struct {
+	K  uint
+	N  uint
+	Bs BitSets
+}
+
+// scalableFilterData 是 ScalableBloomFilter 的可序列化形式
+type scalableFilterData struct {
+	P      float64
+	R      float64
+	S      float64
+	N0     uint
+	Caps   []uint
+	Counts []uint
+	Slices []scalableSliceData
+}
+
+// FileScalableBloomFilter 是落盘的可伸缩布隆过滤器，以gzip压缩的gob编码落盘(FileBloomFilter用的是BFv1二进制格式)
+type FileScalableBloomFilter struct {
+	*ScalableBloomFilter
+	target string
+}
+
+// NewFileScalableBloomFilter 创建一个落盘的可伸缩布隆过滤器
+func NewFileScalableBloomFilter(target string, n0 uint, p float64, s float64, r float64) *FileScalableBloomFilter {
+	filter := &FileScalableBloomFilter{
+		NewScalableBloomFilter(n0, p, s, r), target,
+	}
+	filter.reStore()
+
+	return filter
+}
+
+func (filter *FileScalableBloomFilter) Close() {
+	filter.store()
+	filter.slices = nil
+}
+
+func (filter *FileScalableBloomFilter) store() {
+	f, err := os.Create(filter.target)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "Open file"))
+	}
+	defer f.Close()
+
+	gzipWriter := gzip.NewWriter(f)
+	defer gzipWriter.Close()
+
+	data := scalableFilterData{
+		P:      filter.p,
+		R:      filter.r,
+		S:      filter.s,
+		N0:     filter.n0,
+		Caps:   filter.caps,
+		Counts: filter.counts,
+		Slices: make([]scalableSliceData, len(filter.slices)),
+	}
+	for i, slice := range filter.slices {
+		data.Slices[i] = scalableSliceData{K: slice.k, N: slice.n, Bs: slice.bs}
+	}
+
+	encoder := gob.NewEncoder(gzipWriter)
+	err = encoder.Encode(data)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "gzip"))
+	}
+}
+
+func (filter *FileScalableBloomFilter) reStore() {
+	f, err := os.Open(filter.target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		log.Fatalf("%+v", errors.Wrap(err, "Open file"))
+	}
+	defer f.Close()
+
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "Ungzip"))
+	}
+
+	var data scalableFilterData
+	decoder := gob.NewDecoder(gzipReader)
+	err = decoder.Decode(&data)
+	if err != nil {
+		log.Fatalf("%+v", errors.Wrap(err, "gob decode"))
+	}
+
+	filter.p, filter.r, filter.s, filter.n0 = data.P, data.R, data.S, data.N0
+	filter.caps, filter.counts = data.Caps, data.Counts
+	filter.slices = make([]*MemoryBloomFilter, len(data.Slices))
+	for i, slice := range data.Slices {
+		filter.slices[i] = &MemoryBloomFilter{k: slice.K, n: slice.N, bs: slice.Bs}
+	}
+}